@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// annotationModePrefix sets the file mode of a single key, e.g.
+	// kubersync.crewjam.com/mode.tls.crt: "0640".
+	annotationModePrefix = "kubersync.crewjam.com/mode."
+	// annotationOwnerPrefix sets the owner of a single key as "user:group",
+	// either name resolves via os/user or a numeric uid/gid.
+	annotationOwnerPrefix = "kubersync.crewjam.com/owner."
+	// annotationPathPrefix remaps a key to a different path under the
+	// target directory, e.g. kubersync.crewjam.com/path.tls.crt: "certs/tls.crt".
+	annotationPathPrefix = "kubersync.crewjam.com/path."
+	// annotationDefaultMode sets the fallback file mode for keys that have
+	// no annotationModePrefix annotation of their own.
+	annotationDefaultMode = "kubersync.crewjam.com/default-mode"
+)
+
+// resolvePath returns the path, relative to a target's local directory,
+// that key should be written to: either the value of a
+// kubersync.crewjam.com/path.<key> annotation, or key itself. It rejects
+// remappings that would escape the target directory.
+func resolvePath(key string, annotations map[string]string) (string, error) {
+	rel := key
+	if v, ok := annotations[annotationPathPrefix+key]; ok && v != "" {
+		rel = v
+	}
+	rel = filepath.Clean(rel)
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("path for key %q escapes the target directory: %q", key, rel)
+	}
+	return rel, nil
+}
+
+// applyFileMetadata chmods and, if requested, chowns path according to the
+// kubersync.crewjam.com/mode.<key>, /owner.<key>, and /default-mode
+// annotations.
+func applyFileMetadata(path, key string, annotations map[string]string) error {
+	mode := os.FileMode(0644)
+	if v, ok := annotations[annotationDefaultMode]; ok {
+		m, err := parseFileMode(v)
+		if err != nil {
+			return err
+		}
+		mode = m
+	}
+	if v, ok := annotations[annotationModePrefix+key]; ok {
+		m, err := parseFileMode(v)
+		if err != nil {
+			return err
+		}
+		mode = m
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+
+	v, ok := annotations[annotationOwnerPrefix+key]
+	if !ok {
+		return nil
+	}
+	uid, gid, err := resolveOwner(v)
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
+func parseFileMode(s string) (os.FileMode, error) {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid file mode %q: %v", s, err)
+	}
+	return os.FileMode(v), nil
+}
+
+// resolveOwner parses an "owner:group" string, where owner and group may
+// each be a name (resolved via os/user) or a numeric id, and either half
+// may be omitted to leave that id unchanged.
+func resolveOwner(s string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+
+	parts := strings.SplitN(s, ":", 2)
+	if parts[0] != "" {
+		if uid, err = lookupUID(parts[0]); err != nil {
+			return -1, -1, err
+		}
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		if gid, err = lookupGID(parts[1]); err != nil {
+			return -1, -1, err
+		}
+	}
+	return uid, gid, nil
+}
+
+func lookupUID(name string) (int, error) {
+	if uid, err := strconv.Atoi(name); err == nil {
+		return uid, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, fmt.Errorf("cannot resolve owner %q: %v", name, err)
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+func lookupGID(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return -1, fmt.Errorf("cannot resolve group %q: %v", name, err)
+	}
+	return strconv.Atoi(g.Gid)
+}
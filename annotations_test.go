@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestResolvePath(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		annotations map[string]string
+		want        string
+		wantErr     bool
+	}{
+		{
+			name: "defaults to the key itself",
+			key:  "tls.crt",
+			want: "tls.crt",
+		},
+		{
+			name:        "honors a path annotation",
+			key:         "tls.crt",
+			annotations: map[string]string{annotationPathPrefix + "tls.crt": "certs/tls.crt"},
+			want:        "certs/tls.crt",
+		},
+		{
+			name:        "ignores an empty path annotation",
+			key:         "tls.crt",
+			annotations: map[string]string{annotationPathPrefix + "tls.crt": ""},
+			want:        "tls.crt",
+		},
+		{
+			name:        "rejects a bare ..",
+			key:         "tls.crt",
+			annotations: map[string]string{annotationPathPrefix + "tls.crt": ".."},
+			wantErr:     true,
+		},
+		{
+			name:        "rejects a path that climbs out via ..",
+			key:         "tls.crt",
+			annotations: map[string]string{annotationPathPrefix + "tls.crt": "../../etc/passwd"},
+			wantErr:     true,
+		},
+		{
+			name:        "rejects an absolute path",
+			key:         "tls.crt",
+			annotations: map[string]string{annotationPathPrefix + "tls.crt": "/etc/passwd"},
+			wantErr:     true,
+		},
+		{
+			name:        "cleans a path that only looks like it escapes",
+			key:         "tls.crt",
+			annotations: map[string]string{annotationPathPrefix + "tls.crt": "a/../tls.crt"},
+			want:        "tls.crt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolvePath(tt.key, tt.annotations)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("resolvePath(%q, %v) = %q, nil; want an error", tt.key, tt.annotations, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolvePath(%q, %v) returned error: %v", tt.key, tt.annotations, err)
+			}
+			if got != tt.want {
+				t.Errorf("resolvePath(%q, %v) = %q, want %q", tt.key, tt.annotations, got, tt.want)
+			}
+		})
+	}
+}
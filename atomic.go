@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// kubersyncDataDir is the name of the symlink kubersync keeps pointed at
+// the currently live staged directory, the same ..data convention kubelet
+// uses for projected Secret/ConfigMap volumes.
+const kubersyncDataDir = "..data"
+
+// isInternalName reports whether a single path component belongs to
+// kubersync's own staging machinery (the ..data symlink or a
+// ..data_<timestamp> staging directory) rather than synced file content.
+func isInternalName(name string) bool {
+	return name == kubersyncDataDir || strings.HasPrefix(name, kubersyncDataDir+"_")
+}
+
+// hashCacheSuffix is the suffix hashFilePath (hash.go) appends to a
+// Target's path to name its on-disk content-hash cache. It's checked here
+// too, defensively: hashFilePath keeps that file as a sibling of the
+// synced directory rather than inside it, but if anything ever landed at
+// that name inside the directory, the stale-entry sweep below must not
+// treat it as synced content and delete it.
+const hashCacheSuffix = ".kubersync-hash"
+
+// isInternalPath reports whether any component of path is an internal
+// name, so file-change events inside a staging directory can be ignored.
+func isInternalPath(path string) bool {
+	for _, part := range strings.Split(filepath.Clean(path), string(filepath.Separator)) {
+		if isInternalName(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkSyncedFiles calls fn once for every synced leaf file under root,
+// skipping kubersync's own internal entries. A target whose key was
+// remapped into a subdirectory (kubersync.crewjam.com/path.<key>:
+// "certs/tls.crt") only gets a symlink at the top-level component
+// ("certs"), pointing at a directory inside ..data; filepath.Walk never
+// follows a symlink, so it would never descend into one. walkSyncedFiles
+// resolves that symlink and recurses manually instead. path is the file's
+// location joined through the top-level symlink (not resolved to its
+// ..data target), which is enough to read it; rel is its path relative to
+// root with the top-level symlink left intact, matching what resolvePath
+// produced when the file was written.
+func walkSyncedFiles(root string, fn func(path, rel string) error) error {
+	return walkSyncedDir(root, root, fn)
+}
+
+func walkSyncedDir(root, dir string, fn func(path, rel string) error) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if isInternalName(name) || strings.HasSuffix(name, hashCacheSuffix) {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		isDir := entry.IsDir()
+		if entry.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			isDir = target.IsDir()
+		}
+
+		if isDir {
+			if err := walkSyncedDir(root, path, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if err := fn(path, rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLocalFromObject atomically replaces the contents of localPath with
+// obj's data, the same way kubelet projects Secret/ConfigMap volumes: the
+// new tree is staged in a ..data_<timestamp> directory, then a ..data
+// symlink is renamed onto it in one atomic step, and every top-level key is
+// exposed as a symlink into ..data. Readers never see a half-written tree.
+// A key's path, mode, and owner can be overridden via the
+// kubersync.crewjam.com/{path,mode,owner}.<key> annotations; see
+// resolvePath and applyFileMetadata.
+func writeLocalFromObject(localPath string, obj interface{}) error {
+	data := objectData(obj)
+	annotations := objectAnnotations(obj)
+
+	stageName := fmt.Sprintf("%s_%d", kubersyncDataDir, time.Now().UnixNano())
+	stageDir := filepath.Join(localPath, stageName)
+	if err := os.MkdirAll(stageDir, 0755); err != nil {
+		return err
+	}
+
+	topLevel := map[string]bool{}
+	for key, value := range data {
+		rel, err := resolvePath(key, annotations)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(stageDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, value, 0644); err != nil {
+			return err
+		}
+		if err := applyFileMetadata(path, key, annotations); err != nil {
+			return err
+		}
+		topLevel[strings.SplitN(rel, string(filepath.Separator), 2)[0]] = true
+	}
+
+	dataLink := filepath.Join(localPath, kubersyncDataDir)
+	previousStage, _ := os.Readlink(dataLink)
+
+	tmpLink := dataLink + ".tmp"
+	os.Remove(tmpLink)
+	if err := os.Symlink(stageName, tmpLink); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpLink, dataLink); err != nil {
+		return err
+	}
+	fmt.Println("write", dataLink, "->", stageName)
+
+	for name := range topLevel {
+		linkPath := filepath.Join(localPath, name)
+		if fi, err := os.Lstat(linkPath); err == nil && fi.Mode()&os.ModeSymlink == 0 {
+			// linkPath is a real file or (non-empty) directory rather than
+			// a symlink from a previous run, e.g. the first sync over a
+			// directory that already existed on disk. The stale-entry
+			// sweep below skips every top-level key name, so it never
+			// removes it, and os.Rename can't replace a directory with a
+			// symlink: clear it out now instead.
+			if err := os.RemoveAll(linkPath); err != nil {
+				return err
+			}
+		}
+		tmpLink := linkPath + ".kubersync-tmp"
+		os.Remove(tmpLink)
+		if err := os.Symlink(filepath.Join(kubersyncDataDir, name), tmpLink); err != nil {
+			return err
+		}
+		if err := os.Rename(tmpLink, linkPath); err != nil {
+			return err
+		}
+	}
+
+	entries, err := ioutil.ReadDir(localPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if isInternalName(name) || topLevel[name] || strings.HasSuffix(name, hashCacheSuffix) {
+			continue
+		}
+		fmt.Println("delete", filepath.Join(localPath, name))
+		if err := os.RemoveAll(filepath.Join(localPath, name)); err != nil {
+			return err
+		}
+	}
+
+	if previousStage != "" && previousStage != stageName {
+		os.RemoveAll(filepath.Join(localPath, previousStage))
+	}
+	return nil
+}
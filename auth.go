@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dockerAuthLocks serializes writeDockerAuth's read-modify-write cycle per
+// path, so two merge-secrets targets that share an auth.json don't race and
+// clobber each other's registries. Keyed by path rather than a single
+// mutex so targets writing to different files aren't serialized together.
+var (
+	dockerAuthLocksMu sync.Mutex
+	dockerAuthLocks   = map[string]*sync.Mutex{}
+)
+
+func lockDockerAuthPath(path string) *sync.Mutex {
+	dockerAuthLocksMu.Lock()
+	defer dockerAuthLocksMu.Unlock()
+	mu, ok := dockerAuthLocks[path]
+	if !ok {
+		mu = &sync.Mutex{}
+		dockerAuthLocks[path] = mu
+	}
+	return mu
+}
+
+// dockerConfigJSON is the containers/image auth.json / docker config.json
+// layout: a map of registry host to an opaque per-registry entry. We keep
+// entries as json.RawMessage so fields we don't understand (identitytoken,
+// etc.) round-trip untouched.
+type dockerConfigJSON struct {
+	Auths map[string]json.RawMessage `json:"auths"`
+}
+
+// dockerAuthsFromSecretData extracts the registry auth map from a Secret's
+// data, accepting both the modern .dockerconfigjson key and the legacy
+// .dockercfg key (which has no "auths" wrapper).
+func dockerAuthsFromSecretData(data map[string][]byte) (map[string]json.RawMessage, error) {
+	if raw, ok := data[".dockerconfigjson"]; ok {
+		var cfg dockerConfigJSON
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("cannot parse .dockerconfigjson: %v", err)
+		}
+		return cfg.Auths, nil
+	}
+	if raw, ok := data[".dockercfg"]; ok {
+		var auths map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &auths); err != nil {
+			return nil, fmt.Errorf("cannot parse .dockercfg: %v", err)
+		}
+		return auths, nil
+	}
+	return nil, fmt.Errorf("secret has neither a .dockerconfigjson nor a .dockercfg key")
+}
+
+// writeDockerAuth projects a dockerconfigjson/dockercfg Secret into an
+// auth.json file at path. When merge is true, registries already present
+// in an existing auth.json at path (from this or any other source) are
+// preserved unless this Secret also declares them, in which case this
+// Secret's entry wins.
+func writeDockerAuth(path string, data map[string][]byte, merge bool) error {
+	auths, err := dockerAuthsFromSecretData(data)
+	if err != nil {
+		return err
+	}
+
+	mu := lockDockerAuthPath(path)
+	mu.Lock()
+	defer mu.Unlock()
+
+	combined := map[string]json.RawMessage{}
+	if merge {
+		if existing, err := ioutil.ReadFile(path); err == nil {
+			var cfg dockerConfigJSON
+			if err := json.Unmarshal(existing, &cfg); err == nil {
+				for registry, entry := range cfg.Auths {
+					combined[registry] = entry
+				}
+			}
+		}
+	}
+	for registry, entry := range auths {
+		combined[registry] = entry
+	}
+
+	contents, err := json.MarshalIndent(dockerConfigJSON{Auths: combined}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	os.MkdirAll(filepath.Dir(path), 0755)
+	fmt.Println("write", path)
+	return ioutil.WriteFile(path, contents, 0600)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readAuths(t *testing.T, path string) map[string]json.RawMessage {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	var cfg dockerConfigJSON
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		t.Fatalf("parsing %s: %v", path, err)
+	}
+	return cfg.Auths
+}
+
+func TestWriteDockerAuthMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubersync-auth-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "auth.json")
+
+	existing := []byte(`{"auths":{"registry-a.example.com":{"auth":"YQ=="},"registry-b.example.com":{"auth":"Yg=="}}}`)
+	if err := ioutil.WriteFile(path, existing, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	secretData := map[string][]byte{
+		".dockerconfigjson": []byte(`{"auths":{"registry-b.example.com":{"auth":"Yg1uZXc="}}}`),
+	}
+	if err := writeDockerAuth(path, secretData, true); err != nil {
+		t.Fatalf("writeDockerAuth: %v", err)
+	}
+
+	auths := readAuths(t, path)
+	if _, ok := auths["registry-a.example.com"]; !ok {
+		t.Errorf("merge=true should preserve registry-a.example.com from the existing file")
+	}
+	var b struct{ Auth string }
+	if err := json.Unmarshal(auths["registry-b.example.com"], &b); err != nil {
+		t.Fatalf("parsing registry-b entry: %v", err)
+	}
+	if b.Auth != "Yg1uZXc=" {
+		t.Errorf("merge=true should let the Secret's entry win over the existing one: got auth %q", b.Auth)
+	}
+}
+
+func TestWriteDockerAuthOverwrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubersync-auth-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "auth.json")
+
+	existing := []byte(`{"auths":{"registry-a.example.com":{"auth":"YQ=="}}}`)
+	if err := ioutil.WriteFile(path, existing, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	secretData := map[string][]byte{
+		".dockerconfigjson": []byte(`{"auths":{"registry-b.example.com":{"auth":"Yg=="}}}`),
+	}
+	if err := writeDockerAuth(path, secretData, false); err != nil {
+		t.Fatalf("writeDockerAuth: %v", err)
+	}
+
+	auths := readAuths(t, path)
+	if _, ok := auths["registry-a.example.com"]; ok {
+		t.Errorf("merge=false should discard the existing registry-a.example.com entry")
+	}
+	if _, ok := auths["registry-b.example.com"]; !ok {
+		t.Errorf("merge=false should still write the Secret's own entry")
+	}
+}
+
+func TestWriteDockerAuthLegacyDockercfg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubersync-auth-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "auth.json")
+
+	secretData := map[string][]byte{
+		".dockercfg": []byte(`{"registry-a.example.com":{"auth":"YQ=="}}`),
+	}
+	if err := writeDockerAuth(path, secretData, false); err != nil {
+		t.Fatalf("writeDockerAuth: %v", err)
+	}
+
+	auths := readAuths(t, path)
+	if _, ok := auths["registry-a.example.com"]; !ok {
+		t.Errorf("legacy .dockercfg key should be accepted")
+	}
+}
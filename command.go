@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// resyncPeriod is how often we get updates of the k8s state even if nothing has changed
+const resyncPeriod = 30 * time.Second
+
+// watcherKey identifies a shared informer: one is started per
+// (namespace, kind) pair and fans out events to every Target that lives
+// in it.
+type watcherKey struct {
+	namespace string
+	kind      Kind
+}
+
+// watcher wraps the informer backing a single watcherKey. names records
+// which object names it was built to cover, so addTarget can tell whether
+// adding another Target to the same (namespace, kind) requires widening
+// the watch.
+type watcher struct {
+	store      cache.Store
+	controller cache.Controller
+	stopCh     chan struct{}
+	names      map[string]bool
+}
+
+// Command implements kubersync: it loads a Config, starts one informer per
+// (namespace, kind) pair found in it, and reconciles each declared Target
+// through a rate-limited workqueue so a single slow or failing target
+// can't block the others and transient errors retry with backoff instead
+// of being logged and dropped.
+type Command struct {
+	kubeClient *kubernetes.Clientset
+	configPath string
+	workers    int
+
+	// mergeSecrets, when true, causes docker-auth render targets to union
+	// their registries with whatever is already on disk at their path
+	// instead of overwriting it, so multiple pull-secret targets can share
+	// one auth.json.
+	mergeSecrets bool
+
+	mu       sync.Mutex
+	watchers map[watcherKey]*watcher
+	targets  map[string]*targetState // keyed by Target.id()
+
+	queue workqueue.RateLimitingInterface
+
+	stopCh chan struct{}
+}
+
+// targetState is the per-Target runtime state: the fields that used to live
+// directly on Command before kubersync supported more than one target.
+type targetState struct {
+	target Target
+
+	haveSynced bool
+
+	// lastAppliedHash is the content hash kubersync last applied for this
+	// target, either by writing the local files or by pushing them to the
+	// cluster object, seeded from disk on startup so a restarted process
+	// doesn't redo a write that already landed.
+	lastAppliedHash string
+
+	stopFileWatch chan struct{}
+}
+
+// Start loads the config at configPath and begins syncing every target it
+// declares, using workers reconcile goroutines.
+func (s *Command) Start(kubeClient *kubernetes.Clientset, configPath string, workers int) error {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	s.kubeClient = kubeClient
+	s.configPath = configPath
+	s.workers = workers
+	s.watchers = map[watcherKey]*watcher{}
+	s.targets = map[string]*targetState{}
+	s.queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	s.stopCh = make(chan struct{})
+
+	for i := 0; i < workers; i++ {
+		go s.runWorker()
+	}
+
+	for _, t := range cfg.Targets {
+		if err := s.addTarget(t); err != nil {
+			s.Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+// addTarget registers a Target, starting its (namespace, kind) informer if
+// this is the first Target to need it, and enqueues it for an initial
+// reconcile.
+func (s *Command) addTarget(t Target) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts := &targetState{
+		target:          t,
+		lastAppliedHash: readLastAppliedHash(t),
+		stopFileWatch:   make(chan struct{}),
+	}
+	s.targets[t.id()] = ts
+
+	wk := watcherKey{namespace: t.Namespace, kind: t.Kind}
+	w, ok := s.watchers[wk]
+	if !ok || !w.names[t.Name] {
+		names := map[string]bool{t.Name: true}
+		if ok {
+			for name := range w.names {
+				names[name] = true
+			}
+		}
+
+		var err error
+		w, err = s.startWatcher(wk, names)
+		if err != nil {
+			return err
+		}
+		if ok {
+			close(s.watchers[wk].stopCh) // replacing a narrower watcher that no longer covers every target
+		}
+		s.watchers[wk] = w
+	}
+
+	if !cache.WaitForCacheSync(w.stopCh, w.controller.HasSynced) {
+		return fmt.Errorf("timed out waiting for %s/%s cache to sync", t.Namespace, t.Kind)
+	}
+
+	s.queue.Add(t.id())
+
+	go s.watchFileChanges(ts)
+	return nil
+}
+
+// removeTarget stops syncing a Target that has been dropped from the
+// config, without disturbing any other Target sharing its informer.
+func (s *Command) removeTarget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts, ok := s.targets[id]
+	if !ok {
+		return
+	}
+	close(ts.stopFileWatch)
+	delete(s.targets, id)
+
+	wk := watcherKey{namespace: ts.target.Namespace, kind: ts.target.Kind}
+	for _, other := range s.targets {
+		if other.target.Namespace == wk.namespace && other.target.Kind == wk.kind {
+			return // another target still needs this informer
+		}
+	}
+	if w, ok := s.watchers[wk]; ok {
+		close(w.stopCh)
+		delete(s.watchers, wk)
+	}
+}
+
+// startWatcher starts an informer covering wk.kind objects in wk.namespace
+// named in names. When names has exactly one entry the watch is narrowed
+// to that object with a field selector; the apiserver only supports an
+// equality selector on metadata.name, so with more than one name the
+// informer falls back to watching (and caching) every object of that kind
+// in the namespace.
+func (s *Command) startWatcher(wk watcherKey, names map[string]bool) (*watcher, error) {
+	var resource string
+	var objType interface{}
+	switch wk.kind {
+	case KindSecret:
+		resource, objType = "secrets", &v1.Secret{}
+	case KindConfigMap:
+		resource, objType = "configmaps", &v1.ConfigMap{}
+	default:
+		return nil, fmt.Errorf("unknown kind %q", wk.kind)
+	}
+
+	selector := fields.Everything()
+	if len(names) == 1 {
+		for name := range names {
+			selector = fields.OneTermEqualSelector("metadata.name", name)
+		}
+	}
+
+	listWatch := cache.NewListWatchFromClient(s.kubeClient.Core().RESTClient(), resource, wk.namespace, selector)
+	eventHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			s.enqueue(wk.kind, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			s.enqueue(wk.kind, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			s.enqueue(wk.kind, obj)
+		},
+	}
+
+	w := &watcher{stopCh: make(chan struct{}), names: names}
+	w.store, w.controller = cache.NewInformer(listWatch, objType, resyncPeriod, eventHandler)
+	go w.controller.Run(w.stopCh)
+	return w, nil
+}
+
+// Stop stops every informer, file watcher, and worker started by Start.
+func (s *Command) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ts := range s.targets {
+		close(ts.stopFileWatch)
+	}
+	for _, w := range s.watchers {
+		close(w.stopCh)
+	}
+	s.queue.ShutDown()
+	close(s.stopCh)
+}
+
+// Reload re-reads the config file and starts or stops targets so the
+// running set matches it, without disturbing targets that are unchanged.
+func (s *Command) Reload() error {
+	cfg, err := LoadConfig(s.configPath)
+	if err != nil {
+		return fmt.Errorf("reload: %v", err)
+	}
+
+	wanted := map[string]Target{}
+	for _, t := range cfg.Targets {
+		wanted[t.id()] = t
+	}
+
+	s.mu.Lock()
+	var toRemove []string
+	for id := range s.targets {
+		if _, ok := wanted[id]; !ok {
+			toRemove = append(toRemove, id)
+		} else {
+			delete(wanted, id) // already running and unchanged
+		}
+	}
+	s.mu.Unlock()
+
+	for _, id := range toRemove {
+		fmt.Println("reload: removing target", id)
+		s.removeTarget(id)
+	}
+	for id, t := range wanted {
+		fmt.Println("reload: adding target", id)
+		if err := s.addTarget(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueue looks up the Target for a watched object and, if found, adds it
+// to the workqueue. Both informer events and resyncs fire this, so
+// duplicate keys are intentional and cheap: the workqueue dedups.
+func (s *Command) enqueue(kind Kind, obj interface{}) {
+	// A relist can deliver a delete as a DeletedFinalStateUnknown tombstone
+	// instead of the typed object, when the informer missed the actual
+	// delete event and only noticed the object was gone on the next list.
+	// Unwrap it so objectMeta still sees a *v1.Secret/*v1.ConfigMap instead
+	// of silently falling through its default case.
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	namespace, name := objectMeta(obj)
+
+	s.mu.Lock()
+	ts, ok := s.targets[targetID(kind, namespace, name)]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	s.queue.Add(ts.target.id())
+}
+
+// runWorker pulls target ids off the queue and reconciles them until the
+// queue is shut down.
+func (s *Command) runWorker() {
+	for s.processNextItem() {
+	}
+}
+
+func (s *Command) processNextItem() bool {
+	item, shutdown := s.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer s.queue.Done(item)
+
+	id := item.(string)
+	if err := s.reconcile(id); err != nil {
+		fmt.Fprintf(os.Stderr, "reconcile %s: %v\n", id, err)
+		s.queue.AddRateLimited(item)
+		return true
+	}
+	s.queue.Forget(item)
+	return true
+}
+
+// reconcile is the sole writer of both the local filesystem and the
+// Kubernetes API for a Target: it loads both sides and applies whichever
+// direction(s) the Target is configured for.
+func (s *Command) reconcile(id string) error {
+	s.mu.Lock()
+	ts, ok := s.targets[id]
+	var w *watcher
+	if ok {
+		w = s.watchers[watcherKey{namespace: ts.target.Namespace, kind: ts.target.Kind}]
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil // target was removed by a config reload
+	}
+
+	obj, exists, err := w.store.GetByKey(ts.target.Key())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		// Unlike a single-target kubersync, one process manages many
+		// targets here, so losing one object shouldn't take the others
+		// down with it: just stop touching local files until it reappears.
+		if ts.haveSynced {
+			fmt.Fprintf(os.Stderr, "%s %s was deleted, pausing sync for this target\n", ts.target.Kind, ts.target.Key())
+			ts.haveSynced = false
+		}
+		return nil
+	}
+
+	if ts.target.Direction == DirectionPull || ts.target.Direction == DirectionTwoWay {
+		hash := objectAnnotations(obj)[annotationContentHash]
+		if hash == "" {
+			hash = contentHash(objectData(obj))
+		}
+		if hash != ts.lastAppliedHash {
+			if err := s.writeLocal(ts, obj); err != nil {
+				return err
+			}
+			ts.lastAppliedHash = hash
+			if err := writeLastAppliedHash(ts.target, hash); err != nil {
+				return err
+			}
+		}
+	}
+	if ts.target.Direction == DirectionPush || ts.target.Direction == DirectionTwoWay {
+		if err := s.pushLocalToCluster(ts); err != nil {
+			return err
+		}
+	}
+	ts.haveSynced = true
+	return nil
+}
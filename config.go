@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Kind is the type of Kubernetes object a Target syncs.
+type Kind string
+
+const (
+	// KindSecret syncs a core/v1 Secret.
+	KindSecret Kind = "Secret"
+	// KindConfigMap syncs a core/v1 ConfigMap.
+	KindConfigMap Kind = "ConfigMap"
+)
+
+// Direction controls which side of a Target is authoritative.
+type Direction string
+
+const (
+	// DirectionPush copies local file changes up to the Kubernetes object.
+	DirectionPush Direction = "push"
+	// DirectionPull copies the Kubernetes object down to local files.
+	DirectionPull Direction = "pull"
+	// DirectionTwoWay syncs changes in either direction.
+	DirectionTwoWay Direction = "two-way"
+)
+
+// RenderMode controls how a Target's data is projected onto disk.
+type RenderMode string
+
+const (
+	// RenderRaw writes one file per Secret/ConfigMap key (the default).
+	RenderRaw RenderMode = ""
+	// RenderDockerAuth projects a kubernetes.io/dockerconfigjson (or
+	// legacy kubernetes.io/dockercfg) Secret into the auth.json layout
+	// used by containers/image, podman, and skopeo.
+	RenderDockerAuth RenderMode = "docker-auth"
+)
+
+// Target describes one object/directory pair that kubersync keeps in sync.
+type Target struct {
+	Namespace string     `json:"namespace" yaml:"namespace"`
+	Name      string     `json:"name" yaml:"name"`
+	Kind      Kind       `json:"kind" yaml:"kind"`
+	Path      string     `json:"path" yaml:"path"`
+	Direction Direction  `json:"direction" yaml:"direction"`
+	Render    RenderMode `json:"render,omitempty" yaml:"render,omitempty"`
+}
+
+// Key identifies a Target by the object it watches. It matches the cache
+// key client-go's informer store uses, "namespace/name".
+func (t Target) Key() string {
+	return t.Namespace + "/" + t.Name
+}
+
+// id identifies a Target uniquely within a Command, including its Kind so
+// a Secret and a ConfigMap with the same namespace/name don't collide.
+func (t Target) id() string {
+	return targetID(t.Kind, t.Namespace, t.Name)
+}
+
+func targetID(kind Kind, namespace, name string) string {
+	return string(kind) + "/" + namespace + "/" + name
+}
+
+func (t Target) validate() error {
+	if t.Namespace == "" {
+		return fmt.Errorf("target %s: namespace is required", t.Name)
+	}
+	if t.Name == "" {
+		return fmt.Errorf("target in namespace %s: name is required", t.Namespace)
+	}
+	if t.Path == "" {
+		return fmt.Errorf("target %s: path is required", t.Key())
+	}
+	switch t.Kind {
+	case KindSecret, KindConfigMap:
+	case "":
+		return fmt.Errorf("target %s: kind is required", t.Key())
+	default:
+		return fmt.Errorf("target %s: unknown kind %q", t.Key(), t.Kind)
+	}
+	switch t.Direction {
+	case DirectionPush, DirectionPull, DirectionTwoWay:
+	case "":
+		return fmt.Errorf("target %s: direction is required", t.Key())
+	default:
+		return fmt.Errorf("target %s: unknown direction %q", t.Key(), t.Direction)
+	}
+	switch t.Render {
+	case RenderRaw:
+	case RenderDockerAuth:
+		if t.Kind != KindSecret {
+			return fmt.Errorf("target %s: render: docker-auth is only valid for a Secret", t.Key())
+		}
+		if t.Direction != DirectionPull {
+			return fmt.Errorf("target %s: render: docker-auth is only valid with direction pull", t.Key())
+		}
+	default:
+		return fmt.Errorf("target %s: unknown render mode %q", t.Key(), t.Render)
+	}
+	return nil
+}
+
+// Config is the top-level structure of the -config file.
+type Config struct {
+	Targets []Target `json:"targets" yaml:"targets"`
+}
+
+// LoadConfig reads and validates the config file at path. JSON and YAML are
+// both accepted; the format is chosen based on the file extension, falling
+// back to YAML (which is a superset of JSON) for anything else.
+func LoadConfig(path string) (*Config, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config file: %v", err)
+	}
+
+	var c Config
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(buf, &c); err != nil {
+			return nil, fmt.Errorf("cannot parse config file: %v", err)
+		}
+	} else {
+		if err := yaml.Unmarshal(buf, &c); err != nil {
+			return nil, fmt.Errorf("cannot parse config file: %v", err)
+		}
+	}
+
+	if len(c.Targets) == 0 {
+		return nil, fmt.Errorf("config file %s declares no targets", path)
+	}
+	seen := map[string]bool{}
+	for _, t := range c.Targets {
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+		key := string(t.Kind) + "/" + t.Key()
+		if seen[key] {
+			return nil, fmt.Errorf("target %s (%s) declared more than once", t.Key(), t.Kind)
+		}
+		seen[key] = true
+	}
+	return &c, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"k8s.io/api/core/v1"
+)
+
+// annotationContentHash records the SHA-256 kubersync last applied to an
+// object's data, the same sync-checksum pattern GitOps controllers use to
+// decide whether a cluster object is already in sync with its source. It
+// lets both sides of a Target skip a write whose content wouldn't change
+// anything, which is what stops our own writes from bouncing back and
+// forth between the Secret/ConfigMap and the local files.
+const annotationContentHash = "kubersync.crewjam.com/content-hash"
+
+// contentHash computes a stable hash over data's (key, value) pairs: the
+// keys are sorted first so iteration order never affects the result.
+func contentHash(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write(data[k])
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// setAnnotation sets a single annotation on a *v1.Secret or *v1.ConfigMap.
+func setAnnotation(obj interface{}, key, value string) {
+	switch o := obj.(type) {
+	case *v1.Secret:
+		if o.Annotations == nil {
+			o.Annotations = map[string]string{}
+		}
+		o.Annotations[key] = value
+	case *v1.ConfigMap:
+		if o.Annotations == nil {
+			o.Annotations = map[string]string{}
+		}
+		o.Annotations[key] = value
+	}
+}
+
+// hashFilePath is where a Target's last-applied content hash is cached on
+// disk, so a restarted process can tell it's already in sync without
+// re-reading and re-writing every key. It's always a sibling of t.Path
+// rather than something under it, so it's never mistaken for synced
+// content: pushLocalToCluster would otherwise pick it up as a key of its
+// own, and writeLocalFromObject's stale-entry sweep would delete it.
+func hashFilePath(t Target) string {
+	return filepath.Clean(t.Path) + ".kubersync-hash"
+}
+
+func readLastAppliedHash(t Target) string {
+	b, err := ioutil.ReadFile(hashFilePath(t))
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func writeLastAppliedHash(t Target, hash string) error {
+	return ioutil.WriteFile(hashFilePath(t), []byte(hash), 0600)
+}
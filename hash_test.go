@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestContentHashIgnoresKeyOrder(t *testing.T) {
+	a := map[string][]byte{"one": []byte("1"), "two": []byte("2")}
+	b := map[string][]byte{"two": []byte("2"), "one": []byte("1")}
+	if contentHash(a) != contentHash(b) {
+		t.Errorf("contentHash should not depend on map iteration order")
+	}
+}
+
+func TestContentHashDistinguishesKeyValueBoundary(t *testing.T) {
+	// "one"+"two" and "onetw"+"o" must not hash the same: the null-byte
+	// separators exist precisely to keep this distinct.
+	a := map[string][]byte{"one": []byte("two")}
+	b := map[string][]byte{"onetw": []byte("o")}
+	if contentHash(a) == contentHash(b) {
+		t.Errorf("contentHash collided across a key/value boundary shift")
+	}
+}
+
+func TestContentHashChangesWithContent(t *testing.T) {
+	a := map[string][]byte{"key": []byte("value")}
+	b := map[string][]byte{"key": []byte("other")}
+	if contentHash(a) == contentHash(b) {
+		t.Errorf("contentHash should differ when a value changes")
+	}
+}
+
+func TestHashFilePathIsOutsideTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		want   string
+	}{
+		{
+			name:   "raw render",
+			target: Target{Path: "/sync/my-secret"},
+			want:   "/sync/my-secret.kubersync-hash",
+		},
+		{
+			name:   "docker-auth render",
+			target: Target{Path: "/sync/auth.json", Render: RenderDockerAuth},
+			want:   "/sync/auth.json.kubersync-hash",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hashFilePath(tt.target); got != tt.want {
+				t.Errorf("hashFilePath(%+v) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
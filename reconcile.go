@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/rjeczalik/notify"
+	"k8s.io/api/core/v1"
+)
+
+// objectMeta returns the namespace and name of a *v1.Secret or
+// *v1.ConfigMap without the caller needing to know which.
+func objectMeta(obj interface{}) (namespace, name string) {
+	switch o := obj.(type) {
+	case *v1.Secret:
+		return o.Namespace, o.Name
+	case *v1.ConfigMap:
+		return o.Namespace, o.Name
+	default:
+		return "", ""
+	}
+}
+
+// objectAnnotations returns the annotations of a *v1.Secret or *v1.ConfigMap.
+func objectAnnotations(obj interface{}) map[string]string {
+	switch o := obj.(type) {
+	case *v1.Secret:
+		return o.Annotations
+	case *v1.ConfigMap:
+		return o.Annotations
+	default:
+		return nil
+	}
+}
+
+// objectData returns the key/value pairs of a *v1.Secret or *v1.ConfigMap
+// as a single map[string][]byte, regardless of which it is. A ConfigMap's
+// BinaryData and Data share one key space on the API server, so both are
+// merged in here; withObjectData splits them back apart the same way.
+func objectData(obj interface{}) map[string][]byte {
+	switch o := obj.(type) {
+	case *v1.Secret:
+		return o.Data
+	case *v1.ConfigMap:
+		data := make(map[string][]byte, len(o.Data)+len(o.BinaryData))
+		for k, v := range o.Data {
+			data[k] = []byte(v)
+		}
+		for k, v := range o.BinaryData {
+			data[k] = v
+		}
+		return data
+	default:
+		return nil
+	}
+}
+
+// withObjectData returns a copy of obj with its data replaced by data. A
+// ConfigMap key round-trips through BinaryData, rather than Data, if it
+// isn't valid UTF-8, since the API server rejects non-UTF-8 values in Data.
+func withObjectData(obj interface{}, data map[string][]byte) interface{} {
+	switch o := obj.(type) {
+	case *v1.Secret:
+		new := o.DeepCopy()
+		new.Data = data
+		return new
+	case *v1.ConfigMap:
+		new := o.DeepCopy()
+		new.Data = make(map[string]string, len(data))
+		new.BinaryData = nil
+		for k, v := range data {
+			if utf8.Valid(v) {
+				new.Data[k] = string(v)
+			} else {
+				if new.BinaryData == nil {
+					new.BinaryData = map[string][]byte{}
+				}
+				new.BinaryData[k] = v
+			}
+		}
+		return new
+	default:
+		return nil
+	}
+}
+
+// writeLocal projects obj onto disk the way ts.target.Render says to.
+func (s *Command) writeLocal(ts *targetState, obj interface{}) error {
+	if ts.target.Render == RenderDockerAuth {
+		return writeDockerAuth(ts.target.Path, objectData(obj), s.mergeSecrets)
+	}
+	return writeLocalFromObject(ts.target.Path, obj)
+}
+
+// watchFileChanges enqueues ts for reconciliation every time a file under
+// its path changes. Only push and two-way targets are watched; for a pull
+// target it just waits for ts.stopFileWatch. Events inside a ..data/..data_* staging directory are
+// kubersync's own atomic-write machinery, not user edits, and are ignored.
+func (s *Command) watchFileChanges(ts *targetState) error {
+	if ts.target.Direction != DirectionPush && ts.target.Direction != DirectionTwoWay {
+		<-ts.stopFileWatch
+		return nil
+	}
+
+	c := make(chan notify.EventInfo, 1000)
+	if err := notify.Watch(filepath.Join(ts.target.Path, "..."), c, notify.All); err != nil {
+		return err
+	}
+	defer notify.Stop(c)
+
+	for {
+		select {
+		case ei := <-c:
+			if isInternalPath(ei.Path()) {
+				continue
+			}
+			fmt.Println(ei)
+			s.queue.Add(ts.target.id())
+		case <-ts.stopFileWatch:
+			return nil
+		}
+	}
+}
+
+// pushLocalToCluster reads every file under the target's path and, if the
+// content hash of those files differs from the cluster object's
+// content-hash annotation, updates the object's data and that annotation.
+func (s *Command) pushLocalToCluster(ts *targetState) error {
+	s.mu.Lock()
+	w := s.watchers[watcherKey{namespace: ts.target.Namespace, kind: ts.target.Kind}]
+	s.mu.Unlock()
+
+	obj, exists, err := w.store.GetByKey(ts.target.Key())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	oldData := objectData(obj)
+	annotations := objectAnnotations(obj)
+
+	// Invert the key->path remapping declared by path.<key> annotations so
+	// a file doesn't come back as a new key equal to its own relative path.
+	keyForPath := map[string]string{}
+	for key := range oldData {
+		rel, err := resolvePath(key, annotations)
+		if err != nil {
+			return err
+		}
+		keyForPath[rel] = key
+	}
+
+	newData := map[string][]byte{}
+	if err := walkSyncedFiles(ts.target.Path, func(path, relPath string) error {
+		current, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key, ok := keyForPath[relPath]
+		if !ok {
+			key = relPath
+		}
+		newData[key] = current
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	hash := contentHash(newData)
+	if hash == annotations[annotationContentHash] {
+		return nil // cluster object's content-hash already matches the local files
+	}
+	updated := withObjectData(obj, newData)
+	setAnnotation(updated, annotationContentHash, hash)
+
+	switch ts.target.Kind {
+	case KindSecret:
+		if _, err := s.kubeClient.Core().Secrets(ts.target.Namespace).Update(updated.(*v1.Secret)); err != nil {
+			return err
+		}
+	case KindConfigMap:
+		if _, err := s.kubeClient.Core().ConfigMaps(ts.target.Namespace).Update(updated.(*v1.ConfigMap)); err != nil {
+			return err
+		}
+	}
+	ts.lastAppliedHash = hash
+	if err := writeLastAppliedHash(ts.target, hash); err != nil {
+		return err
+	}
+
+	fmt.Println("updated", ts.target.Kind, ts.target.Key())
+	return nil
+}